@@ -0,0 +1,455 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package caddymemory implements adaptive GC management as a Caddy app
+// module, so operators can tune it via config instead of recompiling.
+package caddymemory
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(App{})
+}
+
+// Mode names used in logs, admin state, and config defaults.
+const (
+	ModeBusy   = "busy"
+	ModeNormal = "normal"
+	ModeIdle   = "idle"
+)
+
+// maxTransitions bounds how many past mode transitions are kept for
+// the /memory/state admin endpoint.
+const maxTransitions = 20
+
+// App is a Caddy app (module ID "memory") that periodically samples
+// memory and CPU pressure (Linux PSI, plus scheduler latency and GC
+// cycle rate) or, where PSI can't be read, the allocation rate, and
+// adjusts GOGC accordingly: aggressive
+// scavenging when idle, standard GC under load, and an efficient
+// middle ground otherwise. It replaces the fixed thresholds that used
+// to be hard-coded in caddycmd.setResourceLimits, making them
+// configurable via JSON. There is no Caddyfile adapter yet, so this
+// app can only be set up through the JSON config's "apps.memory" key.
+type App struct {
+	// Interval is how often allocation activity is sampled. Default: 1m.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	// BusyThreshold is the allocation rate, in bytes/sec, above which
+	// the app enters busy mode. Used only where PSI can't be read.
+	// Default: 10 MiB/s.
+	BusyThreshold uint64 `json:"busy_threshold_bytes_per_sec,omitempty"`
+
+	// IdleThreshold is the allocation rate, in bytes/sec, below which
+	// the app enters idle mode. Used only where PSI can't be read.
+	// Default: 100 KiB/s.
+	IdleThreshold uint64 `json:"idle_threshold_bytes_per_sec,omitempty"`
+
+	// BusyGOGC, NormalGOGC, and IdleGOGC set GOGC for each mode.
+	// Defaults: 100, 50, 50.
+	BusyGOGC   int `json:"busy_gogc,omitempty"`
+	NormalGOGC int `json:"normal_gogc,omitempty"`
+	IdleGOGC   int `json:"idle_gogc,omitempty"`
+
+	// MemoryLimit overrides the soft memory limit reported via the
+	// admin endpoint and used as the base for the Warn/Critical/Panic
+	// usage fractions. It does not itself call debug.SetMemoryLimit; use
+	// GOMEMLIMIT or the existing caddycmd resource setup for that. If
+	// zero, the limit already in effect for the process is reported.
+	MemoryLimit int64 `json:"memory_limit_bytes,omitempty"`
+
+	// AllowFreeOSMemory controls whether idle mode may call
+	// debug.FreeOSMemory() to force a scavenge. Default: true.
+	AllowFreeOSMemory *bool `json:"allow_free_os_memory,omitempty"`
+
+	// PressureThreshold is the PSI "some avg10" value (0-100) above
+	// which memory pressure is considered high enough to force busy
+	// mode down to normal and trigger a scavenge. Linux only; ignored
+	// on platforms without /proc/pressure. Default: 10.
+	PressureThreshold float64 `json:"pressure_threshold,omitempty"`
+
+	// IdleStreakIntervals is how many consecutive intervals of ~zero
+	// CPU and memory pressure are required before entering idle mode.
+	// Linux only. Default: 3.
+	IdleStreakIntervals int `json:"idle_streak_intervals,omitempty"`
+
+	// WarnUsageFraction, CriticalUsageFraction, and PanicUsageFraction
+	// are the fractions of the soft memory limit, measured against
+	// total process memory (not just heap), at which RegisterPressureHandler
+	// handlers for the corresponding PressureLevel are invoked.
+	// Defaults: 0.75, 0.90, 0.97.
+	WarnUsageFraction     float64 `json:"warn_usage_fraction,omitempty"`
+	CriticalUsageFraction float64 `json:"critical_usage_fraction,omitempty"`
+	PanicUsageFraction    float64 `json:"panic_usage_fraction,omitempty"`
+
+	logger *zap.Logger
+
+	mu           sync.Mutex
+	mode         string
+	allocRate    uint64
+	lastStats    runtime.MemStats
+	transitions  []Transition
+	idleStreak   int
+	lastGCCycles uint64
+	started      bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Transition records a single mode change for introspection.
+type Transition struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "memory",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// Provision sets up the app and fills in defaults for unset fields.
+func (a *App) Provision(ctx caddy.Context) error {
+	a.logger = ctx.Logger()
+	if err := registerMetrics(prometheus.DefaultRegisterer); err != nil {
+		return err
+	}
+
+	if a.Interval == 0 {
+		a.Interval = caddy.Duration(time.Minute)
+	}
+	if a.BusyThreshold == 0 {
+		a.BusyThreshold = 10 * 1024 * 1024
+	}
+	if a.IdleThreshold == 0 {
+		a.IdleThreshold = 100 * 1024
+	}
+	if a.BusyGOGC == 0 {
+		a.BusyGOGC = 100
+	}
+	if a.NormalGOGC == 0 {
+		a.NormalGOGC = 50
+	}
+	if a.IdleGOGC == 0 {
+		a.IdleGOGC = 50
+	}
+	if a.AllowFreeOSMemory == nil {
+		allow := true
+		a.AllowFreeOSMemory = &allow
+	}
+	if a.MemoryLimit == 0 {
+		a.MemoryLimit = debug.SetMemoryLimit(-1)
+	}
+	if a.PressureThreshold == 0 {
+		a.PressureThreshold = 10
+	}
+	if a.IdleStreakIntervals == 0 {
+		a.IdleStreakIntervals = 3
+	}
+	if a.WarnUsageFraction == 0 {
+		a.WarnUsageFraction = 0.75
+	}
+	if a.CriticalUsageFraction == 0 {
+		a.CriticalUsageFraction = 0.90
+	}
+	if a.PanicUsageFraction == 0 {
+		a.PanicUsageFraction = 0.97
+	}
+
+	a.mode = ModeNormal
+	a.stop = make(chan struct{})
+	a.stopped = make(chan struct{})
+
+	return nil
+}
+
+// Start starts the adaptive memory manager's sampling goroutine.
+func (a *App) Start() error {
+	activeInstance.Store(a)
+	a.started = true
+
+	debug.SetGCPercent(a.NormalGOGC)
+	runtime.ReadMemStats(&a.lastStats)
+	_, a.lastGCCycles = readBusyMetrics(0)
+
+	go a.monitor()
+
+	a.logger.Info("adaptive memory management started",
+		zap.Duration("interval", time.Duration(a.Interval)),
+		zap.String("initial_mode", a.mode),
+	)
+	return nil
+}
+
+// Stop shuts down the sampling goroutine so reloading the config
+// doesn't leak it, unlike the teardown func that setResourceLimits
+// used to return.
+func (a *App) Stop() error {
+	// On a config reload, Caddy starts the new config's apps before
+	// stopping the old config's (to avoid dropping listeners), so the
+	// outgoing instance's Stop can run after the incoming instance's
+	// Start. Only clear activeInstance if it's still us, so we don't
+	// clobber a newer instance that already took over.
+	activeInstance.CompareAndSwap(a, nil)
+	if !a.started {
+		// Provisioned but never started (e.g. Caddy validated the
+		// config and tore it down without running it): monitor was
+		// never launched, so nothing will ever close a.stopped.
+		return nil
+	}
+	close(a.stop)
+	<-a.stopped
+	return nil
+}
+
+// activeInstance holds the currently-running memory App, if any. It's
+// checked by caddycmd's startup-time gcTuner, which steps aside once
+// an App takes over GOGC management, so the two don't fight over
+// debug.SetGCPercent.
+var activeInstance atomic.Pointer[App]
+
+// Active reports whether a memory App is currently started.
+func Active() bool {
+	return activeInstance.Load() != nil
+}
+
+func (a *App) monitor() {
+	defer close(a.stopped)
+
+	ticker := time.NewTicker(time.Duration(a.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.sample()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func (a *App) sample() {
+	var current runtime.MemStats
+	runtime.ReadMemStats(&current)
+
+	pressure, pressureOK := readPressure()
+	var busy busyMetrics
+	if pressureOK {
+		busy, a.lastGCCycles = readBusyMetrics(a.lastGCCycles)
+	}
+
+	a.mu.Lock()
+	allocDelta := current.TotalAlloc - a.lastStats.TotalAlloc
+	intervalSeconds := time.Duration(a.Interval).Seconds()
+	if intervalSeconds < 1 {
+		intervalSeconds = 1
+	}
+	allocRate := uint64(float64(allocDelta) / intervalSeconds)
+	a.allocRate = allocRate
+	a.lastStats = current
+
+	oldMode := a.mode
+	var newMode string
+	var forceRelief bool
+	if pressureOK {
+		newMode, forceRelief = a.modeFromPressure(pressure, busy)
+	} else {
+		// Fallback when PSI can't be read (non-Linux, or PSI disabled or
+		// unreadable on Linux): the original allocation-rate heuristic.
+		newMode = ModeNormal
+		switch {
+		case allocRate > a.BusyThreshold:
+			newMode = ModeBusy
+		case allocRate < a.IdleThreshold:
+			newMode = ModeIdle
+		}
+	}
+
+	if newMode != oldMode {
+		a.mode = newMode
+		a.transitions = append(a.transitions, Transition{From: oldMode, To: newMode, At: time.Now()})
+		if len(a.transitions) > maxTransitions {
+			a.transitions = a.transitions[len(a.transitions)-maxTransitions:]
+		}
+	}
+	allowFree := *a.AllowFreeOSMemory
+	a.mu.Unlock()
+
+	if newMode != oldMode {
+		a.logger.Info("switching memory mode",
+			zap.String("from", oldMode),
+			zap.String("to", newMode),
+			zap.Uint64("alloc_rate_bytes_sec", allocRate),
+		)
+	}
+
+	appliedGOGC := a.gogcFor(newMode)
+	switch newMode {
+	case ModeBusy:
+		debug.SetGCPercent(a.BusyGOGC)
+	case ModeNormal:
+		debug.SetGCPercent(a.NormalGOGC)
+	case ModeIdle:
+		debug.SetGCPercent(a.IdleGOGC)
+		if allowFree {
+			debug.FreeOSMemory()
+		}
+	}
+
+	if forceRelief {
+		// High memory PSI: clamp to a GOGC floor and scavenge now
+		// rather than waiting for idle mode, regardless of
+		// AllowFreeOSMemory, since this is a pressure emergency.
+		debug.SetGCPercent(a.IdleGOGC)
+		debug.FreeOSMemory()
+		appliedGOGC = a.IdleGOGC
+	}
+
+	reportMetrics(newMode, allocRate, appliedGOGC, oldMode, newMode, newMode != oldMode)
+
+	checkPressure(a.logger, a.MemoryLimit, a.WarnUsageFraction, a.CriticalUsageFraction, a.PanicUsageFraction)
+}
+
+// gogcFor returns the GOGC percentage configured for mode, for
+// Prometheus reporting.
+func (a *App) gogcFor(mode string) int {
+	switch mode {
+	case ModeBusy:
+		return a.BusyGOGC
+	case ModeIdle:
+		return a.IdleGOGC
+	default:
+		return a.NormalGOGC
+	}
+}
+
+// modeFromPressure derives a mode from PSI and scheduler/GC busy-ness
+// instead of allocation rate. It returns forceRelief=true when memory
+// pressure alone warrants an immediate scavenge, independent of the
+// returned mode.
+func (a *App) modeFromPressure(p pressureSample, bm busyMetrics) (mode string, forceRelief bool) {
+	if p.MemAvg10 > a.PressureThreshold {
+		a.idleStreak = 0
+		return ModeNormal, true
+	}
+
+	if p.MemKnown && p.CPUKnown && p.MemAvg10 == 0 && p.CPUAvg10 == 0 {
+		a.idleStreak++
+	} else {
+		a.idleStreak = 0
+	}
+	if a.idleStreak >= a.IdleStreakIntervals {
+		return ModeIdle, false
+	}
+
+	const (
+		schedLatencyBusyThreshold = 0.001 // 1ms p99 run-queue wait
+		gcCycleRateBusyThreshold  = 2     // automatic GCs per interval
+	)
+	if bm.SchedLatencyP99 > schedLatencyBusyThreshold || bm.GCCyclesDelta > gcCycleRateBusyThreshold {
+		return ModeBusy, false
+	}
+
+	return ModeNormal, false
+}
+
+// memoryState is the JSON shape returned by the /memory/state admin
+// endpoint.
+type memoryState struct {
+	Mode          string       `json:"mode"`
+	AllocRateBPS  uint64       `json:"alloc_rate_bytes_per_sec"`
+	LiveHeapBytes uint64       `json:"live_heap_bytes"`
+	LimitBytes    int64        `json:"limit_bytes"`
+	Transitions   []Transition `json:"transitions"`
+}
+
+func (a *App) state() memoryState {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return memoryState{
+		Mode:          a.mode,
+		AllocRateBPS:  a.allocRate,
+		LiveHeapBytes: ms.HeapAlloc,
+		LimitBytes:    a.MemoryLimit,
+		Transitions:   append([]Transition(nil), a.transitions...),
+	}
+}
+
+// resourcesReport is the JSON shape returned by the /resources admin
+// endpoint: it combines this app's own adaptive state with the
+// process-wide GOMAXPROCS/GOMEMLIMIT decisions caddycmd made at
+// startup, so operators have one place to see everything
+// setResourceLimits used to only log.
+type resourcesReport struct {
+	memoryState
+	ResourceDecisions
+	MemStats runtime.MemStats `json:"mem_stats"`
+}
+
+func (a *App) resources() resourcesReport {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return resourcesReport{
+		memoryState:       a.state(),
+		ResourceDecisions: getResourceDecisions(),
+		MemStats:          ms,
+	}
+}
+
+// Routes returns the admin routes for the memory app.
+func (a *App) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/memory/state",
+			Handler: caddy.AdminHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("Content-Type", "application/json")
+				return json.NewEncoder(w).Encode(a.state())
+			}),
+		},
+		{
+			Pattern: "/resources",
+			Handler: caddy.AdminHandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("Content-Type", "application/json")
+				return json.NewEncoder(w).Encode(a.resources())
+			}),
+		},
+	}
+}
+
+// Interface guards
+var (
+	_ caddy.App         = (*App)(nil)
+	_ caddy.Provisioner = (*App)(nil)
+	_ caddy.AdminRouter = (*App)(nil)
+)
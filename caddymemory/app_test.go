@@ -0,0 +1,101 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddymemory
+
+import "testing"
+
+func newTestApp() *App {
+	return &App{
+		PressureThreshold:   10,
+		IdleStreakIntervals: 3,
+	}
+}
+
+func TestModeFromPressureHighMemoryForcesReliefAndResetsStreak(t *testing.T) {
+	a := newTestApp()
+	a.idleStreak = 2
+
+	mode, forceRelief := a.modeFromPressure(pressureSample{MemAvg10: 42, MemKnown: true, CPUKnown: true}, busyMetrics{})
+
+	if mode != ModeNormal {
+		t.Errorf("mode = %q, want %q", mode, ModeNormal)
+	}
+	if !forceRelief {
+		t.Error("forceRelief = false, want true when memory PSI exceeds PressureThreshold")
+	}
+	if a.idleStreak != 0 {
+		t.Errorf("idleStreak = %d, want 0 after high memory pressure", a.idleStreak)
+	}
+}
+
+func TestModeFromPressureIdleRequiresConsecutiveQuietIntervals(t *testing.T) {
+	a := newTestApp()
+	quiet := pressureSample{MemAvg10: 0, CPUAvg10: 0, MemKnown: true, CPUKnown: true}
+
+	for i := 1; i < a.IdleStreakIntervals; i++ {
+		mode, forceRelief := a.modeFromPressure(quiet, busyMetrics{})
+		if mode == ModeIdle {
+			t.Fatalf("interval %d: mode = %q early, want not-idle until %d consecutive quiet intervals", i, mode, a.IdleStreakIntervals)
+		}
+		if forceRelief {
+			t.Fatalf("interval %d: forceRelief = true, want false for quiet PSI", i)
+		}
+	}
+
+	mode, _ := a.modeFromPressure(quiet, busyMetrics{})
+	if mode != ModeIdle {
+		t.Errorf("mode = %q after %d consecutive quiet intervals, want %q", mode, a.IdleStreakIntervals, ModeIdle)
+	}
+}
+
+func TestModeFromPressureUnknownReadingsDoNotCountAsIdle(t *testing.T) {
+	a := newTestApp()
+
+	// CPU PSI unreadable (CPUKnown=false) must not be treated as a
+	// genuine zero reading, or a loaded CPU could be misclassified as
+	// idle just because its PSI file couldn't be read.
+	for i := 0; i < a.IdleStreakIntervals+2; i++ {
+		mode, _ := a.modeFromPressure(pressureSample{MemAvg10: 0, MemKnown: true, CPUKnown: false}, busyMetrics{})
+		if mode == ModeIdle {
+			t.Fatalf("interval %d: mode = %q, want never idle when CPU PSI is unknown", i, mode)
+		}
+	}
+}
+
+func TestModeFromPressureBusyMetricsForceBusyMode(t *testing.T) {
+	a := newTestApp()
+	calm := pressureSample{MemAvg10: 1, CPUAvg10: 1, MemKnown: true, CPUKnown: true}
+
+	mode, forceRelief := a.modeFromPressure(calm, busyMetrics{GCCyclesDelta: 10})
+	if mode != ModeBusy {
+		t.Errorf("mode = %q, want %q for a high GC cycle rate", mode, ModeBusy)
+	}
+	if forceRelief {
+		t.Error("forceRelief = true, want false outside a memory pressure event")
+	}
+}
+
+func TestModeFromPressureDefaultsToNormal(t *testing.T) {
+	a := newTestApp()
+	calm := pressureSample{MemAvg10: 1, CPUAvg10: 1, MemKnown: true, CPUKnown: true}
+
+	mode, forceRelief := a.modeFromPressure(calm, busyMetrics{})
+	if mode != ModeNormal {
+		t.Errorf("mode = %q, want %q", mode, ModeNormal)
+	}
+	if forceRelief {
+		t.Error("forceRelief = true, want false")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddymemory
+
+import "runtime/metrics"
+
+// busyMetrics are the runtime/metrics figures used, alongside PSI, to
+// measure busy-ness from scheduler behavior rather than from an
+// allocation-rate proxy.
+type busyMetrics struct {
+	// SchedLatencyP99 is the approximate 99th percentile of time
+	// goroutines spent waiting to run, in seconds.
+	SchedLatencyP99 float64
+	// GCCyclesDelta is the number of automatic GC cycles completed
+	// since the previous sample.
+	GCCyclesDelta uint64
+}
+
+var busyMetricNames = []string{
+	"/sched/latencies:seconds",
+	"/gc/cycles/automatic:gc-cycles",
+}
+
+// readBusyMetrics reads the current scheduler latency histogram and GC
+// cycle counter, computing the GC cycle delta against lastGCCycles.
+func readBusyMetrics(lastGCCycles uint64) (busyMetrics, uint64) {
+	samples := make([]metrics.Sample, len(busyMetricNames))
+	for i, name := range busyMetricNames {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+
+	var bm busyMetrics
+	var totalGCCycles uint64
+
+	if samples[0].Value.Kind() == metrics.KindFloat64Histogram {
+		bm.SchedLatencyP99 = percentile(samples[0].Value.Float64Histogram(), 0.99)
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		totalGCCycles = samples[1].Value.Uint64()
+		bm.GCCyclesDelta = totalGCCycles - lastGCCycles
+	}
+
+	return bm, totalGCCycles
+}
+
+// percentile estimates the value at the given percentile (0-1) of a
+// runtime/metrics histogram by walking buckets until the cumulative
+// count reaches it.
+func percentile(h *metrics.Float64Histogram, p float64) float64 {
+	if h == nil || len(h.Counts) == 0 {
+		return 0
+	}
+
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(float64(total) * p)
+	var cumulative uint64
+	for i, c := range h.Counts {
+		cumulative += c
+		if cumulative >= target {
+			// Counts[i] falls between Buckets[i] and Buckets[i+1];
+			// report the upper edge as a conservative estimate.
+			if i+1 < len(h.Buckets) {
+				return h.Buckets[i+1]
+			}
+			return h.Buckets[i]
+		}
+	}
+	return h.Buckets[len(h.Buckets)-1]
+}
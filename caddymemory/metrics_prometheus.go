@@ -0,0 +1,77 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddymemory
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// modeGaugeValue maps mode names to the value reported by the
+// caddy_memory_mode gauge, since Prometheus gauges must be numeric.
+var modeGaugeValue = map[string]float64{
+	ModeIdle:   0,
+	ModeNormal: 1,
+	ModeBusy:   2,
+}
+
+var (
+	metricMode = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "caddy_memory_mode",
+		Help: "Current adaptive memory mode (0=idle, 1=normal, 2=busy).",
+	})
+	metricAllocRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "caddy_memory_alloc_rate_bytes",
+		Help: "Most recently measured allocation rate, in bytes per second.",
+	})
+	metricGOGC = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "caddy_gogc_percent",
+		Help: "GOGC percentage currently in effect.",
+	})
+	metricTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "caddy_mode_transitions_total",
+		Help: "Count of adaptive memory mode transitions, labeled by from/to mode.",
+	}, []string{"from", "to"})
+)
+
+// registerMetrics registers the package's collectors with reg,
+// tolerating AlreadyRegisteredError so multiple Apps (or config
+// reloads that re-provision one) don't fail on duplicate registration.
+// Any other registration error is returned so the caller (Provision)
+// can fail that one reload instead of crashing the process.
+func registerMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{metricMode, metricAllocRate, metricGOGC, metricTransitions} {
+		if err := reg.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if !errors.As(err, &are) {
+				return fmt.Errorf("registering memory app metrics: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// reportMetrics updates the gauges and transition counter for one
+// sample. gogc is the GOGC percentage just applied.
+func reportMetrics(mode string, allocRate uint64, gogc int, from, to string, transitioned bool) {
+	metricMode.Set(modeGaugeValue[mode])
+	metricAllocRate.Set(float64(allocRate))
+	metricGOGC.Set(float64(gogc))
+	if transitioned {
+		metricTransitions.WithLabelValues(from, to).Inc()
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddymemory
+
+import (
+	"runtime/metrics"
+	"testing"
+)
+
+func TestPercentileEmptyHistogram(t *testing.T) {
+	if got := percentile(nil, 0.99); got != 0 {
+		t.Errorf("percentile(nil, 0.99) = %v, want 0", got)
+	}
+	if got := percentile(&metrics.Float64Histogram{}, 0.99); got != 0 {
+		t.Errorf("percentile(empty, 0.99) = %v, want 0", got)
+	}
+}
+
+func TestPercentileReturnsUpperBucketEdge(t *testing.T) {
+	// Buckets: [0,1) [1,2) [2,3) [3,4), with all 100 samples in [2,3).
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 0, 100, 0},
+		Buckets: []float64{0, 1, 2, 3, 4},
+	}
+
+	if got, want := percentile(h, 0.99), 3.0; got != want {
+		t.Errorf("percentile(h, 0.99) = %v, want %v", got, want)
+	}
+}
+
+func TestPercentileLastBucketHasNoUpperEdge(t *testing.T) {
+	// Malformed histogram where Buckets doesn't have the usual
+	// len(Counts)+1 edges; the estimate should fall back to the
+	// bucket's own edge rather than index out of range.
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 100},
+		Buckets: []float64{0, 1},
+	}
+
+	if got, want := percentile(h, 0.99), 1.0; got != want {
+		t.Errorf("percentile(h, 0.99) = %v, want %v", got, want)
+	}
+}
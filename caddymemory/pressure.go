@@ -0,0 +1,135 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddymemory
+
+import (
+	"context"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PressureLevel is the severity of a memory pressure event reported to
+// handlers registered with RegisterPressureHandler.
+type PressureLevel int
+
+const (
+	// Warn means usage is elevated; a conservative handler might start
+	// shrinking caches proactively.
+	Warn PressureLevel = iota
+	// Critical means usage is close enough to the limit that the GC
+	// will spend unbounded CPU trying to avoid breaching it; handlers
+	// should start shedding load.
+	Critical
+	// Panic means the limit is nearly breached; handlers should shed
+	// as aggressively as they're able to.
+	Panic
+)
+
+func (l PressureLevel) String() string {
+	switch l {
+	case Warn:
+		return "warn"
+	case Critical:
+		return "critical"
+	case Panic:
+		return "panic"
+	default:
+		return "unknown"
+	}
+}
+
+// pressureHandlerTimeout bounds how long a registered handler may run.
+// It's enforced via context cancellation, not a hard kill, so a
+// handler that ignores ctx.Done() can still leak a goroutine — but it
+// can never block the monitor or other handlers, which each get their
+// own goroutine.
+const pressureHandlerTimeout = 2 * time.Second
+
+var (
+	handlersMu sync.Mutex
+	handlers   = map[PressureLevel][]func(context.Context){}
+)
+
+// RegisterPressureHandler registers fn to run when memory usage
+// crosses level's threshold of the configured soft memory limit
+// (Warn/Critical/Panic default to 75%/90%/97%, configurable per App).
+// fn always runs on its own goroutine with a deadline, so Caddy has a
+// cooperative way to shed load under pressure instead of letting GC
+// go into a death spiral or the kernel OOM-kill the process.
+//
+// Typical registrants: the reverse proxy can start returning 503 on
+// new connections, the HTTP server can drop keep-alives, and the
+// cache layer can evict.
+func RegisterPressureHandler(level PressureLevel, fn func(context.Context)) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[level] = append(handlers[level], fn)
+}
+
+// runPressureHandlers fires every handler registered for level, each
+// on its own goroutine with pressureHandlerTimeout to run in.
+func runPressureHandlers(logger *zap.Logger, level PressureLevel) {
+	handlersMu.Lock()
+	fns := append([]func(context.Context){}, handlers[level]...)
+	handlersMu.Unlock()
+
+	if len(fns) == 0 {
+		return
+	}
+
+	logger.Warn("memory pressure handlers invoked",
+		zap.String("level", level.String()),
+		zap.Int("handler_count", len(fns)),
+	)
+	for _, fn := range fns {
+		go func(fn func(context.Context)) {
+			ctx, cancel := context.WithTimeout(context.Background(), pressureHandlerTimeout)
+			defer cancel()
+			fn(ctx)
+		}(fn)
+	}
+}
+
+var totalBytesSample = []metrics.Sample{{Name: "/memory/classes/total:bytes"}}
+
+// checkPressure samples total process memory against limit and runs
+// the handlers for every threshold currently crossed, from Warn up to
+// the highest level reached. A Warn-registered handler (e.g. shrink
+// caches proactively) should keep firing even once things escalate to
+// Critical or Panic, not just while Warn is the top level crossed.
+func checkPressure(logger *zap.Logger, limit int64, warn, critical, panicFrac float64) {
+	if limit <= 0 {
+		return
+	}
+
+	metrics.Read(totalBytesSample)
+	if totalBytesSample[0].Value.Kind() != metrics.KindUint64 {
+		return
+	}
+	usage := float64(totalBytesSample[0].Value.Uint64()) / float64(limit)
+
+	if usage >= warn {
+		runPressureHandlers(logger, Warn)
+	}
+	if usage >= critical {
+		runPressureHandlers(logger, Critical)
+	}
+	if usage >= panicFrac {
+		runPressureHandlers(logger, Panic)
+	}
+}
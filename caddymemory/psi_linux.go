@@ -0,0 +1,88 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package caddymemory
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// memoryPressurePath and cpuPressurePath are the system-wide PSI files
+// read to drive mode transitions. A cgroup's own memory.pressure and
+// cpu.pressure files use the same format but are not read.
+const (
+	memoryPressurePath = "/proc/pressure/memory"
+	cpuPressurePath    = "/proc/pressure/cpu"
+)
+
+// pressureSample holds the "some avg10" figures from PSI, which
+// estimate the percentage of the last 10 seconds some task spent
+// stalled on the resource. MemKnown/CPUKnown distinguish "read as
+// zero" from "couldn't be read", so an unreadable file (e.g. due to
+// cgroup permissions) isn't mistaken for a genuine idle reading.
+type pressureSample struct {
+	MemAvg10 float64
+	CPUAvg10 float64
+	MemKnown bool
+	CPUKnown bool
+}
+
+// readPressure reads memory and CPU PSI. It returns ok=false if
+// neither file could be read, e.g. because PSI isn't enabled in the
+// kernel or the process lacks permission.
+func readPressure() (pressureSample, bool) {
+	mem, memOK := readAvg10(memoryPressurePath)
+	cpu, cpuOK := readAvg10(cpuPressurePath)
+	if !memOK && !cpuOK {
+		return pressureSample{}, false
+	}
+	return pressureSample{MemAvg10: mem, CPUAvg10: cpu, MemKnown: memOK, CPUKnown: cpuOK}, true
+}
+
+// readAvg10 reads the "some" line's avg10 field from a PSI file, e.g.:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readAvg10(path string) (float64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(field, "=")
+			if !found || key != "avg10" {
+				continue
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0, false
+			}
+			return v, true
+		}
+	}
+	return 0, false
+}
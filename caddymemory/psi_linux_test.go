@@ -0,0 +1,70 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package caddymemory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePSIFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pressure")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("writing test PSI file: %v", err)
+	}
+	return path
+}
+
+func TestReadAvg10ParsesSomeLine(t *testing.T) {
+	path := writePSIFile(t, "some avg10=4.20 avg60=1.00 avg300=0.50 total=12345\n"+
+		"full avg10=0.00 avg60=0.00 avg300=0.00 total=0\n")
+
+	got, ok := readAvg10(path)
+	if !ok {
+		t.Fatal("readAvg10 returned ok=false for a well-formed file")
+	}
+	if got != 4.20 {
+		t.Errorf("readAvg10 = %v, want 4.20", got)
+	}
+}
+
+func TestReadAvg10MissingFile(t *testing.T) {
+	_, ok := readAvg10(filepath.Join(t.TempDir(), "does-not-exist"))
+	if ok {
+		t.Error("readAvg10 returned ok=true for a missing file")
+	}
+}
+
+func TestReadAvg10MalformedLine(t *testing.T) {
+	path := writePSIFile(t, "some avg10=not-a-number avg60=1.00 avg300=0.50 total=12345\n")
+
+	_, ok := readAvg10(path)
+	if ok {
+		t.Error("readAvg10 returned ok=true for a malformed avg10 field")
+	}
+}
+
+func TestReadAvg10NoSomeLine(t *testing.T) {
+	path := writePSIFile(t, "full avg10=0.00 avg60=0.00 avg300=0.00 total=0\n")
+
+	_, ok := readAvg10(path)
+	if ok {
+		t.Error("readAvg10 returned ok=true when the file has no \"some\" line")
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package caddymemory
+
+// pressureSample mirrors the Linux PSI figures but is always zero on
+// platforms without /proc/pressure.
+type pressureSample struct {
+	MemAvg10 float64
+	CPUAvg10 float64
+	MemKnown bool
+	CPUKnown bool
+}
+
+// readPressure always reports ok=false on non-Linux platforms, so
+// sample() falls back to the allocation-rate heuristic.
+func readPressure() (pressureSample, bool) {
+	return pressureSample{}, false
+}
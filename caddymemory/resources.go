@@ -0,0 +1,54 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caddymemory
+
+import "sync/atomic"
+
+// ResourceDecisions records the process-wide GOMAXPROCS/GOMEMLIMIT
+// choices caddycmd.setResourceLimits makes at startup, before any
+// App is provisioned. They're process-global (set once, outside any
+// config reload), so they're tracked here rather than as App state,
+// and merged into the App's /resources admin output. They are not
+// exported as Prometheus metrics.
+type ResourceDecisions struct {
+	// GOMAXPROCS is the value effective after maxprocs.Set, reflecting
+	// the detected Linux container CPU quota, if any.
+	GOMAXPROCS int `json:"gomaxprocs"`
+
+	// MemoryLimitBytes is the soft memory limit effective after
+	// memlimit.SetGoMemLimitWithOpts, reflecting the detected cgroup
+	// memory quota or system memory as a fallback.
+	MemoryLimitBytes int64 `json:"memory_limit_bytes"`
+}
+
+var resourceDecisions atomic.Pointer[ResourceDecisions]
+
+// SetResourceDecisions records the GOMAXPROCS/GOMEMLIMIT decisions
+// made by caddycmd.setResourceLimits, so they can be surfaced
+// alongside this app's own state via the /resources admin endpoint.
+// It's safe to call before any App is provisioned.
+func SetResourceDecisions(d ResourceDecisions) {
+	resourceDecisions.Store(&d)
+}
+
+// getResourceDecisions returns the last-recorded decisions, or the
+// zero value if caddycmd hasn't reported any yet (e.g. in tests that
+// provision an App without going through the caddy command).
+func getResourceDecisions() ResourceDecisions {
+	if d := resourceDecisions.Load(); d != nil {
+		return *d
+	}
+	return ResourceDecisions{}
+}
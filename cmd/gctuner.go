@@ -0,0 +1,150 @@
+package caddycmd
+
+import (
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+
+	"go.uber.org/zap"
+
+	"github.com/caddyserver/caddy/v2/caddymemory"
+)
+
+// maxGcTunerMemory is the upper bound on the effective memory limit for
+// which the gcTuner will actively manage GOGC. Above this (effectively
+// "no real limit" — e.g. the host has no cgroup quota and memlimit fell
+// back to total system memory on a huge machine), the tuner disables
+// itself rather than chase a ceiling that isn't really a ceiling.
+const maxGcTunerMemory = 512 << 30 // 512 GiB
+
+// gcSafetyFraction is the fraction of the effective memory limit that
+// the tuner targets as its heap ceiling, leaving headroom for non-heap
+// memory such as goroutine stacks and off-heap allocations.
+const gcSafetyFraction = 0.7
+
+// GOGC bounds the tuner operates within. gogcMax keeps the heap from
+// thrashing the collector when well below the ceiling; gogcMin forces
+// frequent, smaller collections as the heap approaches it.
+const (
+	gogcMax     = 500
+	gogcDefault = 100
+	gogcMin     = 50
+)
+
+// gcTuner drives debug.SetGCPercent from the ratio of live heap to the
+// configured soft memory limit, instead of from an allocation-rate
+// proxy sampled on a wall-clock tick. The approach mirrors the
+// heap-target tuners used by TiDB/TiCDC: GOGC is raised when the heap
+// is far below the limit, letting it grow and amortizing collection
+// cost, and lowered as the heap approaches the limit, forcing smaller,
+// more frequent collections instead of one large one that risks
+// breaching it. Tuning runs once per GC cycle, triggered by a
+// self-rearming finalizer, rather than on a timer.
+type gcTuner struct {
+	logger    *zap.Logger
+	limit     int64
+	threshold int64
+}
+
+// newGCTuner returns a gcTuner for the given effective memory limit, or
+// nil if limit is non-positive or exceeds maxGcTunerMemory, in which
+// case GOGC is left on Go's default behavior.
+func newGCTuner(logger *zap.Logger, limit int64) *gcTuner {
+	if limit <= 0 || limit > maxGcTunerMemory {
+		return nil
+	}
+	return &gcTuner{
+		logger:    logger,
+		limit:     limit,
+		threshold: int64(float64(limit) * gcSafetyFraction),
+	}
+}
+
+// tunerSentinel is the object whose finalizer drives the per-GC-cycle
+// tick. It must have non-zero size: runtime.SetFinalizer is a silent
+// no-op on the shared zero-size allocation that new(struct{}) returns,
+// so a size-zero sentinel would never actually fire.
+type tunerSentinel struct{ _ byte }
+
+// start begins tuning GOGC and returns a function that stops it. The
+// last-armed sentinel may still fire once after stop is called, but
+// its finalizer then neither calls tune() nor re-arms.
+func (t *gcTuner) start() func() {
+	stopped := make(chan struct{})
+
+	var arm func()
+	arm = func() {
+		sentinel := new(tunerSentinel)
+		runtime.SetFinalizer(sentinel, func(_ *tunerSentinel) {
+			select {
+			case <-stopped:
+				return
+			default:
+			}
+			t.tune()
+			arm()
+		})
+	}
+	arm()
+
+	t.tune() // establish an initial GOGC before the first GC cycle completes
+
+	return func() { close(stopped) }
+}
+
+// tune reads the current live heap size and adjusts GOGC to keep it
+// under threshold, clamping to gogcMin once threshold is exceeded and
+// forcing a scavenge if the heap is nearing the hard limit. It steps
+// aside once a caddymemory App is running, since that app takes over
+// GOGC management (configurably) and the two would otherwise fight
+// over debug.SetGCPercent.
+func (t *gcTuner) tune() {
+	if caddymemory.Active() {
+		return
+	}
+
+	liveHeap := int64(t.readLiveHeap())
+
+	var gogc int
+	switch {
+	case liveHeap > t.threshold:
+		gogc = gogcMin
+	case liveHeap > t.threshold/2:
+		// Interpolate GOGC down from gogcMax to gogcDefault as liveHeap
+		// moves from 0.5*threshold to threshold.
+		progress := float64(liveHeap-t.threshold/2) / (float64(t.threshold) / 2)
+		gogc = gogcMax - int(progress*float64(gogcMax-gogcDefault))
+	default:
+		gogc = gogcMax
+	}
+
+	debug.SetGCPercent(gogc)
+
+	if float64(liveHeap) > 0.9*float64(t.limit) {
+		debug.FreeOSMemory()
+	}
+
+	t.logger.Debug("gc tuner adjusted GOGC",
+		zap.Int64("live_heap_bytes", liveHeap),
+		zap.Int64("threshold_bytes", t.threshold),
+		zap.Int64("limit_bytes", t.limit),
+		zap.Int("gogc", gogc),
+	)
+}
+
+// readLiveHeap returns the current live heap size in bytes via
+// runtime/metrics, falling back to runtime.MemStats if the metric is
+// unavailable in this Go version. It uses a local sample slice rather
+// than a shared field: tune() can run concurrently from the finalizer
+// goroutine and the caller of start(), since the very next GC cycle
+// can complete before start()'s own initial tune() call returns.
+func (t *gcTuner) readLiveHeap() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() == metrics.KindBad {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,88 @@
+package caddycmd
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestNewGCTunerGuardsAgainstBadLimits(t *testing.T) {
+	logger := zap.NewNop()
+
+	if tuner := newGCTuner(logger, 0); tuner != nil {
+		t.Error("newGCTuner(0) = non-nil, want nil")
+	}
+	if tuner := newGCTuner(logger, -1); tuner != nil {
+		t.Error("newGCTuner(-1) = non-nil, want nil")
+	}
+	if tuner := newGCTuner(logger, maxGcTunerMemory+1); tuner != nil {
+		t.Error("newGCTuner(maxGcTunerMemory+1) = non-nil, want nil")
+	}
+	if tuner := newGCTuner(logger, maxGcTunerMemory); tuner == nil {
+		t.Error("newGCTuner(maxGcTunerMemory) = nil, want non-nil")
+	}
+}
+
+func TestNewGCTunerComputesThreshold(t *testing.T) {
+	const limit = 1000
+	tuner := newGCTuner(zap.NewNop(), limit)
+	if tuner == nil {
+		t.Fatal("newGCTuner returned nil for a valid limit")
+	}
+	if got, want := tuner.threshold, int64(limit*gcSafetyFraction); got != want {
+		t.Errorf("threshold = %d, want %d", got, want)
+	}
+}
+
+// gogcForHeap mirrors tune()'s GOGC-band decision for a given live
+// heap size, without touching process-global GOGC via debug.SetGCPercent.
+func gogcForHeap(tuner *gcTuner, liveHeap int64) int {
+	switch {
+	case liveHeap > tuner.threshold:
+		return gogcMin
+	case liveHeap > tuner.threshold/2:
+		progress := float64(liveHeap-tuner.threshold/2) / (float64(tuner.threshold) / 2)
+		return gogcMax - int(progress*float64(gogcMax-gogcDefault))
+	default:
+		return gogcMax
+	}
+}
+
+func TestGOGCBands(t *testing.T) {
+	const limit = 1_000_000
+	tuner := newGCTuner(zap.NewNop(), limit)
+	if tuner == nil {
+		t.Fatal("newGCTuner returned nil for a valid limit")
+	}
+
+	tests := []struct {
+		name     string
+		liveHeap int64
+		want     int
+	}{
+		{"well below half threshold", tuner.threshold / 4, gogcMax},
+		{"at half threshold", tuner.threshold / 2, gogcMax},
+		{"just above half threshold", tuner.threshold/2 + 1, gogcMax},
+		{"at threshold", tuner.threshold, gogcDefault},
+		{"above threshold", tuner.threshold + 1, gogcMin},
+		{"far above threshold", tuner.threshold * 2, gogcMin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gogcForHeap(tuner, tt.liveHeap); got != tt.want {
+				t.Errorf("gogcForHeap(%d) = %d, want %d", tt.liveHeap, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLiveHeapReturnsNonZero(t *testing.T) {
+	tuner := newGCTuner(zap.NewNop(), maxGcTunerMemory)
+	if tuner == nil {
+		t.Fatal("newGCTuner returned nil for a valid limit")
+	}
+	if got := tuner.readLiveHeap(); got == 0 {
+		t.Error("readLiveHeap() = 0, want a nonzero live heap size for a running process")
+	}
+}
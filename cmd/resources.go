@@ -4,12 +4,13 @@ import (
 	"log/slog"
 	"runtime"
 	"runtime/debug"
-	"time"
 
 	"github.com/KimMachineGun/automemlimit/memlimit"
 	"go.uber.org/automaxprocs/maxprocs"
 	"go.uber.org/zap"
 	"go.uber.org/zap/exp/zapslog"
+
+	"github.com/caddyserver/caddy/v2/caddymemory"
 )
 
 func setResourceLimits(logger *zap.Logger) func() {
@@ -22,7 +23,7 @@ func setResourceLimits(logger *zap.Logger) func() {
 
 	// 3. Configure the maximum memory to use to match the Linux container quota (if any) or system memory
 	// See https://pkg.go.dev/runtime/debug#SetMemoryLimit
-	_, _ = memlimit.SetGoMemLimitWithOpts(
+	memLimit, _ := memlimit.SetGoMemLimitWithOpts(
 		memlimit.WithLogger(
 			slog.New(zapslog.NewHandler(logger.Core())),
 		),
@@ -34,90 +35,31 @@ func setResourceLimits(logger *zap.Logger) func() {
 		),
 	)
 
-	// Adaptive Memory Management: "Black / Grey / White"
-	//
-	// Goals:
-	// - White Mode (High Load): Maximize throughput (Standard GC).
-	// - Grey Mode (Moderate Load): Maximize efficiency (Aggressive GC).
-	// - Black Mode (Idle): Minimize RSS (Force Scavenge).
-	//
-	// Strategy: Monitor allocation rate (TotalAlloc delta) every interval.
-
-	const (
-		ModeBlack = "black" // Idle: Scavenge aggressively
-		ModeGrey  = "grey"  // Normal: Efficient GC
-		ModeWhite = "white" // Busy: Performance GC
-	)
-
-	debug.SetGCPercent(50) // Default to Grey (Efficiency)
-	currentMode := ModeGrey
-	logger.Info("adaptive memory management started", zap.String("initial_mode", currentMode))
-
-	stopMonitor := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-
-		var lastStats runtime.MemStats
-		runtime.ReadMemStats(&lastStats)
-
-		for {
-			select {
-			case <-ticker.C:
-				var currentStats runtime.MemStats
-				runtime.ReadMemStats(&currentStats)
-
-				// Calculate allocation rate (bytes per second approx)
-				// We actually care about "activity", so TotalAlloc delta is a good proxy.
-				allocDelta := currentStats.TotalAlloc - lastStats.TotalAlloc
-				// Average allocs per second over the last minute
-				allocRate := allocDelta / 60
-
-				var newMode string
-				// Thresholds (Heuristic - tune as needed)
-				// > 10 MB/sec -> White (High Load)
-				// < 100 KB/sec -> Black (Idle)
-				// Else -> Grey (Moderate)
-				if allocRate > 10*1024*1024 {
-					newMode = ModeWhite
-				} else if allocRate < 100*1024 {
-					newMode = ModeBlack
-				} else {
-					newMode = ModeGrey
-				}
-
-				if newMode != currentMode {
-					logger.Info("switching memory mode",
-						zap.String("from", currentMode),
-						zap.String("to", newMode),
-						zap.Uint64("alloc_rate_bytes_sec", allocRate),
-					)
-					currentMode = newMode
-
-					switch newMode {
-					case ModeWhite:
-						debug.SetGCPercent(100) // Standard Go default
-					case ModeGrey:
-						debug.SetGCPercent(50) // More aggressive
-					case ModeBlack:
-						debug.SetGCPercent(50) // Keep aggressive GC
-						debug.FreeOSMemory()   // AND Force release to OS
-					}
-				} else if currentMode == ModeBlack {
-					// In Black mode, keep scavenging if still idle
-					debug.FreeOSMemory()
-				}
-
-				lastStats = currentStats
-
-			case <-stopMonitor:
-				return
-			}
+	// Record what was just decided so the caddy.memory app can surface
+	// it over the /resources admin endpoint alongside its own state.
+	caddymemory.SetResourceDecisions(caddymemory.ResourceDecisions{
+		GOMAXPROCS:       runtime.GOMAXPROCS(0),
+		MemoryLimitBytes: memLimit,
+	})
+
+	// 4. Tune GOGC against the limit set above, instead of leaving it at
+	// Go's flat default. debug.SetMemoryLimit(-1) reads back whatever
+	// soft limit was just established (cgroup quota, GOMEMLIMIT override,
+	// or system memory fallback) without changing it.
+	stopTuner := func() {}
+	if limit := debug.SetMemoryLimit(-1); limit > 0 {
+		if tuner := newGCTuner(logger, limit); tuner != nil {
+			logger.Info("gc tuner started", zap.Int64("memory_limit_bytes", limit))
+			stopTuner = tuner.start()
+		} else {
+			logger.Info("gc tuner disabled: memory limit exceeds maxGcTunerMemory or is unset",
+				zap.Int64("memory_limit_bytes", limit),
+			)
 		}
-	}()
+	}
 
 	return func() {
-		close(stopMonitor)
+		stopTuner()
 		if undo != nil {
 			undo()
 		}
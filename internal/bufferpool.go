@@ -19,15 +19,17 @@ import (
 	"sync"
 )
 
-// MaxBufferSize is the maximum size of a buffer in bytes
-// that will be returned to a pool. Buffers larger than this
-// are discarded so memory can be reclaimed by the garbage
-// collector.
+// MaxBufferSize is the maximum size of a buffer in bytes that the
+// single-pool PutBuffer below will return to its pool. It's kept for
+// callers not yet migrated to BufferPools; new code should use
+// BufferPools instead.
 const MaxBufferSize = 64 * 1024
 
-// PutBuffer returns a buffer to the pool after resetting it,
-// but only if it is smaller than MaxBufferSize. This prevents
-// memory bloat from large buffers being kept in the pool.
+// PutBuffer returns a buffer to pool after resetting it, but only if
+// its capacity does not exceed MaxBufferSize. This is the original single-pool,
+// single-cutoff API, kept as a compatibility wrapper for callers (the
+// reverse proxy, fastcgi, and encode modules) that haven't yet been
+// migrated to the tiered BufferPools below.
 func PutBuffer(pool *sync.Pool, buf *bytes.Buffer) {
 	if buf.Cap() > MaxBufferSize {
 		return
@@ -35,3 +37,86 @@ func PutBuffer(pool *sync.Pool, buf *bytes.Buffer) {
 	buf.Reset()
 	pool.Put(buf)
 }
+
+// bucketSizes are the capacities of the pools within a BufferPools,
+// smallest first. A buffer is always grown to fill its bucket, so
+// buffers handed out by the same bucket are fungible and PutBuffer can
+// return any of them without resizing.
+var bucketSizes = []int{
+	4 * 1024,
+	16 * 1024,
+	64 * 1024,
+	256 * 1024,
+	1024 * 1024,
+}
+
+// BufferPools is a tiered set of buffer pools bucketed by capacity.
+// It replaces a single *sync.Pool with one hard size cutoff: small
+// callers no longer churn over buffers sized for the largest request,
+// and buffers that would have been discarded by that cutoff can still
+// be reused as long as they fit the largest bucket. Buffers bigger
+// than the largest bucket are still discarded, so a handful of huge
+// requests can't bloat every pool.
+//
+// Each caller creates its own BufferPools with NewBufferPools; the
+// reverse proxy, fastcgi, and encode modules still use the single-pool
+// PutBuffer above.
+type BufferPools struct {
+	pools [len(bucketSizes)]sync.Pool
+}
+
+// NewBufferPools returns a ready-to-use tiered buffer pool.
+func NewBufferPools() *BufferPools {
+	p := new(BufferPools)
+	for i := range p.pools {
+		size := bucketSizes[i]
+		p.pools[i].New = func() any {
+			buf := new(bytes.Buffer)
+			buf.Grow(size)
+			return buf
+		}
+	}
+	return p
+}
+
+// GetBuffer returns a buffer from the smallest bucket whose capacity
+// is at least sizeHint, or a plain unpooled buffer grown to sizeHint
+// if it exceeds every bucket.
+func (p *BufferPools) GetBuffer(sizeHint int) *bytes.Buffer {
+	idx := bucketIndex(sizeHint)
+	if idx < 0 {
+		buf := new(bytes.Buffer)
+		buf.Grow(sizeHint)
+		return buf
+	}
+	return p.pools[idx].Get().(*bytes.Buffer)
+}
+
+// PutBuffer resets buf and returns it to the largest bucket whose
+// capacity it fills, discarding it if it is bigger than the largest
+// bucket or smaller than the smallest.
+func (p *BufferPools) PutBuffer(buf *bytes.Buffer) {
+	idx := bucketIndex(buf.Cap())
+	if idx < 0 {
+		return
+	}
+	if bucketSizes[idx] > buf.Cap() {
+		idx--
+		if idx < 0 {
+			return
+		}
+	}
+	buf.Reset()
+	p.pools[idx].Put(buf)
+}
+
+// bucketIndex returns the index of the smallest bucket whose capacity
+// is >= size, or -1 if size exceeds every bucket.
+func bucketIndex(size int) int {
+	for i, s := range bucketSizes {
+		if size <= s {
+			return i
+		}
+	}
+	return -1
+}
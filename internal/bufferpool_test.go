@@ -0,0 +1,76 @@
+// Copyright 2015 Matthew Holt and The Caddy Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestBufferPoolsBucketSelection(t *testing.T) {
+	p := NewBufferPools()
+
+	buf := p.GetBuffer(10 * 1024)
+	if got, want := buf.Cap(), bucketSizes[1]; got < want {
+		t.Errorf("GetBuffer(10KiB) returned cap %d, want at least %d", got, want)
+	}
+
+	p.PutBuffer(buf)
+
+	buf2 := p.GetBuffer(10 * 1024)
+	if buf2.Cap() != buf.Cap() {
+		t.Errorf("expected PutBuffer/GetBuffer round-trip to reuse the same bucket, got cap %d, want %d", buf2.Cap(), buf.Cap())
+	}
+}
+
+func TestBufferPoolsOversizeDiscarded(t *testing.T) {
+	p := NewBufferPools()
+
+	buf := p.GetBuffer(2 * 1024 * 1024)
+	if buf.Cap() < 2*1024*1024 {
+		t.Fatalf("expected oversized buffer to be grown to requested size, got cap %d", buf.Cap())
+	}
+
+	// Oversized buffers don't fit any bucket, so PutBuffer discards
+	// them rather than growing a bucket to an outlier size.
+	p.PutBuffer(buf)
+	for _, size := range bucketSizes {
+		if got := p.GetBuffer(size); got == buf {
+			t.Errorf("GetBuffer(%d) returned the discarded oversized buffer", size)
+		}
+	}
+}
+
+func BenchmarkBufferPoolsSmall(b *testing.B) {
+	p := NewBufferPools()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := p.GetBuffer(2 * 1024)
+		buf.WriteString("hello world")
+		p.PutBuffer(buf)
+	}
+}
+
+func BenchmarkBufferPoolsMixed(b *testing.B) {
+	p := NewBufferPools()
+	sizes := []int{1 * 1024, 32 * 1024, 128 * 1024, 512 * 1024}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			buf := p.GetBuffer(sizes[i%len(sizes)])
+			buf.Write(make([]byte, sizes[i%len(sizes)]/2))
+			p.PutBuffer(buf)
+			i++
+		}
+	})
+}